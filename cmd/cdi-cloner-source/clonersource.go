@@ -0,0 +1,260 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"flag"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/blake2b"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+	"kubevirt.io/containerized-data-importer/pkg/common"
+	"kubevirt.io/containerized-data-importer/pkg/util"
+	"kubevirt.io/containerized-data-importer/pkg/util/clone"
+)
+
+var compressionFlag = flag.String("compression", "none", "Compression to apply to the chunked clone payload: none, gzip or zstd")
+
+func init() {
+	klog.InitFlags(nil)
+	flag.Parse()
+}
+
+func main() {
+	defer klog.Flush()
+	klog.V(1).Infoln("Starting cloner source")
+
+	compression, err := resolveCompression(*compressionFlag)
+	if err != nil {
+		klog.Errorf("%+v", err)
+		os.Exit(1)
+	}
+
+	dst, err := openCloneDestination()
+	if err != nil {
+		klog.Errorf("%+v", err)
+		os.Exit(1)
+	}
+	defer dst.Close()
+
+	resumeFromChunk, err := dst.ResumeFromChunk()
+	if err != nil {
+		klog.Warningf("%+v", errors.Wrap(err, "failed to learn the target's resume point, restarting from scratch"))
+		resumeFromChunk = 0
+	}
+	if resumeFromChunk > 0 {
+		klog.V(1).Infof("Resuming clone from chunk %d", resumeFromChunk)
+	}
+
+	volumeMode := string(v1.PersistentVolumeBlock)
+	if _, err := os.Stat(common.ImporterWriteBlockPath); os.IsNotExist(err) {
+		volumeMode = string(v1.PersistentVolumeFilesystem)
+	}
+
+	if volumeMode == string(v1.PersistentVolumeFilesystem) && resumeFromChunk > 0 {
+		// Mirrors the target's own rule: a tar stream can only be produced from the
+		// start, so resume only ever applies to a raw block read.
+		klog.Warningf("Ignoring a nonzero resume point for a filesystem clone; streaming the whole tar from the start")
+		resumeFromChunk = 0
+	}
+
+	header, staged, err := stageClonePayload(volumeMode, compression, resumeFromChunk)
+	if err != nil {
+		klog.Errorf("%+v", err)
+		dst.Report(err)
+		os.Exit(1)
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+
+	if err := clone.WriteFrameHeader(dst, header); err != nil {
+		err = errors.Wrap(err, "failed to write clone frame header")
+		klog.Errorf("%+v", err)
+		dst.Report(err)
+		os.Exit(1)
+	}
+
+	if _, err := io.Copy(dst, staged); err != nil {
+		err = errors.Wrap(err, "failed to stream staged clone payload")
+		klog.Errorf("%+v", err)
+		dst.Report(err)
+		os.Exit(1)
+	}
+
+	dst.Report(nil)
+	klog.V(1).Infoln("clone complete")
+}
+
+// resolveCompression maps -compression to the clone.CompressionAlgo it requests.
+func resolveCompression(name string) (clone.CompressionAlgo, error) {
+	switch name {
+	case "none", "":
+		return clone.CompressionNone, nil
+	case "gzip":
+		return clone.CompressionGzip, nil
+	case "zstd":
+		return clone.CompressionZstd, nil
+	default:
+		return clone.CompressionNone, errors.Errorf("unknown compression %q", name)
+	}
+}
+
+// stageClonePayload chunks, hashes and optionally compresses the data for
+// volumeMode into a temp file, returning the frame header that describes it.
+// Chunking and compressing have to finish before the frame header can be written,
+// since the header declares the on-wire compressed length up front; staging to a
+// file lets the target learn that length before the payload starts arriving, at
+// the cost of a local temp copy of the compressed clone.
+func stageClonePayload(volumeMode string, compression clone.CompressionAlgo, resumeFromChunk uint64) (clone.FrameHeader, *os.File, error) {
+	raw, total, err := openCloneRawSource(volumeMode, resumeFromChunk)
+	if err != nil {
+		return clone.FrameHeader{}, nil, err
+	}
+	defer raw.Close()
+
+	staged, err := ioutil.TempFile("", "clonesource")
+	if err != nil {
+		return clone.FrameHeader{}, nil, err
+	}
+
+	compressedWriter, flush := wrapCompression(staged, compression)
+
+	digest := sha256.New()
+	produced, err := writeChunkedPayload(compressedWriter, raw, resumeFromChunk, digest)
+	if err == nil {
+		err = flush()
+	}
+	if err == nil && volumeMode == string(v1.PersistentVolumeFilesystem) {
+		// The tar archive's size isn't known until it's fully produced, unlike a
+		// block device's, which openCloneRawSource already read off its stat.
+		total = produced
+	}
+	if err == nil {
+		_, err = staged.Write(digest.Sum(nil))
+	}
+	var stagedInfo os.FileInfo
+	if err == nil {
+		stagedInfo, err = staged.Stat()
+	}
+	if err == nil {
+		_, err = staged.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		staged.Close()
+		os.Remove(staged.Name())
+		return clone.FrameHeader{}, nil, errors.Wrap(err, "failed to stage the clone payload")
+	}
+
+	header := clone.FrameHeader{
+		Version:       clone.FrameVersion1,
+		Compression:   compression,
+		Total:         total,
+		CompressedLen: uint64(stagedInfo.Size()) - sha256.Size,
+	}
+	return header, staged, nil
+}
+
+// wrapCompression wraps w with the encoder compression selects, returning the
+// writer chunked payload bytes should be written to along with a flush func that
+// must run before the digest trailer is appended, so a buffering encoder like
+// gzip or zstd can't leave unflushed bytes behind it on the wire.
+func wrapCompression(w io.Writer, compression clone.CompressionAlgo) (io.Writer, func() error) {
+	switch compression {
+	case clone.CompressionGzip:
+		gz := gzip.NewWriter(w)
+		return gz, gz.Close
+	case clone.CompressionZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			// NewWriter only fails on bad options; none of the defaults used here
+			// can trigger that.
+			panic(err)
+		}
+		return zw, zw.Close
+	default:
+		return w, func() error { return nil }
+	}
+}
+
+// openCloneRawSource opens the uncompressed, unchunked bytes to clone for
+// volumeMode. For a block device it also returns the declared total size of the
+// whole device, not just what's left to send after seeking past resumeFromChunk,
+// matching what the target's resumableChunkReader compares its accumulated offset
+// against; for a filesystem clone the total isn't known yet and is reported as 0,
+// to be corrected by the caller once the tar stream has actually been produced.
+func openCloneRawSource(volumeMode string, resumeFromChunk uint64) (io.ReadCloser, uint64, error) {
+	if volumeMode != string(v1.PersistentVolumeBlock) {
+		return openTarCloneSource()
+	}
+
+	f, err := os.Open(common.ImporterWriteBlockPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	if resumeFromChunk > 0 {
+		if _, err := f.Seek(int64(resumeFromChunk)*clone.ChunkSize, io.SeekStart); err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+	}
+	return f, uint64(info.Size()), nil
+}
+
+// openTarCloneSource archives the filesystem volume into a tar stream, the same
+// format util.UnArchiveTar expects on the target.
+func openTarCloneSource() (io.ReadCloser, uint64, error) {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(util.ArchiveTar(w, "."))
+	}()
+	return r, 0, nil
+}
+
+// writeChunkedPayload reads r in clone.ChunkSize pieces, sequencing them starting
+// at firstSequence, framing each with a Blake2b-256 clone.ChunkHeader the way the
+// target's resumableChunkReader expects to parse them back out, and writes the
+// framed chunks to w. digest accumulates only the plaintext chunk bytes, not the
+// chunk headers, matching the target, which computes its own digest after
+// stripping chunk framing back out. It returns the total number of plaintext
+// bytes read from r.
+func writeChunkedPayload(w io.Writer, r io.Reader, firstSequence uint64, digest hash.Hash) (uint64, error) {
+	buf := make([]byte, clone.ChunkSize)
+	sequence := firstSequence
+	var total uint64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return total, err
+		}
+		if n == 0 {
+			return total, nil
+		}
+
+		chunk := buf[:n]
+		sum := blake2b.Sum256(chunk)
+		if err := clone.WriteChunkHeader(w, clone.ChunkHeader{Sequence: sequence, Hash: sum}); err != nil {
+			return total, err
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return total, err
+		}
+		digest.Write(chunk)
+		total += uint64(n)
+		sequence++
+
+		if n < len(buf) {
+			return total, nil
+		}
+	}
+}
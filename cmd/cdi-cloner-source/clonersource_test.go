@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+	"kubevirt.io/containerized-data-importer/pkg/util/clone"
+)
+
+func TestWriteChunkedPayloadFramesEachChunk(t *testing.T) {
+	chunk0 := bytes.Repeat([]byte{0xAA}, clone.ChunkSize)
+	chunk1 := bytes.Repeat([]byte{0xBB}, 16)
+	payload := append(append([]byte{}, chunk0...), chunk1...)
+
+	var wire bytes.Buffer
+	digest := sha256.New()
+	total, err := writeChunkedPayload(&wire, bytes.NewReader(payload), 0, digest)
+	if err != nil {
+		t.Fatalf("writeChunkedPayload returned error: %v", err)
+	}
+	if total != uint64(len(payload)) {
+		t.Fatalf("total = %d, want %d", total, len(payload))
+	}
+
+	wantDigest := sha256.Sum256(payload)
+	if !bytes.Equal(digest.Sum(nil), wantDigest[:]) {
+		t.Fatal("digest did not cover the plaintext chunk bytes")
+	}
+
+	header, err := clone.ReadChunkHeader(&wire)
+	if err != nil {
+		t.Fatalf("ReadChunkHeader returned error: %v", err)
+	}
+	if header.Sequence != 0 {
+		t.Fatalf("first chunk sequence = %d, want 0", header.Sequence)
+	}
+	gotChunk := make([]byte, clone.ChunkSize)
+	if _, err := wire.Read(gotChunk); err != nil {
+		t.Fatalf("failed to read first chunk body: %v", err)
+	}
+	if sum := blake2b.Sum256(gotChunk); sum != header.Hash {
+		t.Fatal("first chunk hash does not match its framed payload")
+	}
+
+	header, err = clone.ReadChunkHeader(&wire)
+	if err != nil {
+		t.Fatalf("ReadChunkHeader returned error for the second chunk: %v", err)
+	}
+	if header.Sequence != 1 {
+		t.Fatalf("second chunk sequence = %d, want 1", header.Sequence)
+	}
+	if sum := blake2b.Sum256(wire.Bytes()); sum != header.Hash {
+		t.Fatal("second chunk hash does not match its framed payload")
+	}
+}
+
+func TestWriteChunkedPayloadSeedsSequenceFromFirstSequence(t *testing.T) {
+	payload := []byte("resumed tail bytes")
+
+	var wire bytes.Buffer
+	if _, err := writeChunkedPayload(&wire, bytes.NewReader(payload), 4, sha256.New()); err != nil {
+		t.Fatalf("writeChunkedPayload returned error: %v", err)
+	}
+
+	header, err := clone.ReadChunkHeader(&wire)
+	if err != nil {
+		t.Fatalf("ReadChunkHeader returned error: %v", err)
+	}
+	if header.Sequence != 4 {
+		t.Fatalf("sequence = %d, want 4", header.Sequence)
+	}
+}
+
+func TestResolveCompression(t *testing.T) {
+	cases := map[string]clone.CompressionAlgo{
+		"":     clone.CompressionNone,
+		"none": clone.CompressionNone,
+		"gzip": clone.CompressionGzip,
+		"zstd": clone.CompressionZstd,
+	}
+	for name, want := range cases {
+		got, err := resolveCompression(name)
+		if err != nil {
+			t.Fatalf("resolveCompression(%q) returned error: %v", name, err)
+		}
+		if got != want {
+			t.Fatalf("resolveCompression(%q) = %v, want %v", name, got, want)
+		}
+	}
+
+	if _, err := resolveCompression("lz4"); err == nil {
+		t.Fatal("expected an error for an unsupported compression, got nil")
+	}
+}
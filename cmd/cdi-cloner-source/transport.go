@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog"
+)
+
+const (
+	transportPipe = "pipe"
+	transportHTTP = "http"
+
+	cloneHTTPPath        = "/v1/clone"
+	cloneResumePointPath = "/v1/resume-point"
+
+	// controlPipeSuffix names the sibling FIFO the target writes to tell this
+	// source which chunk to resume from, alongside the main data pipe. It must
+	// match cmd/cdi-cloner's controlPipeSuffix.
+	controlPipeSuffix = ".ctrl"
+)
+
+// resumePointResponse mirrors the target's response from cloneResumePointPath.
+type resumePointResponse struct {
+	ResumeFromChunk uint64 `json:"resumeFromChunk"`
+}
+
+var (
+	namedPipe      = flag.String("pipedir", "nopipedir", "The name and directory of the named pipe to write to")
+	transportMode  = flag.String("transport", transportPipe, "The clone transport to use: pipe (shared FIFO) or http (mTLS streaming)")
+	targetAddress  = flag.String("target-address", "", "host:port of the target's mTLS clone endpoint (http transport only)")
+	caCertFile     = flag.String("ca-cert-file", "", "Path to the CA certificate used to verify the target's server certificate (http transport only)")
+	clientCertFile = flag.String("client-cert-file", "", "Path to this pod's client certificate presented to the target (http transport only)")
+	clientKeyFile  = flag.String("client-key-file", "", "Path to this pod's client key (http transport only)")
+)
+
+// cloneDestination is a transport-agnostic view of the outgoing clone stream: the
+// frame header, payload and trailing digest are all written to it in order, the
+// same way regardless of whether they're headed over a named pipe or an HTTP
+// request body.
+type cloneDestination interface {
+	io.WriteCloser
+	// ResumeFromChunk asks the target which chunk sequence number to start from,
+	// 0 meaning the whole clone should be sent from the start.
+	ResumeFromChunk() (uint64, error)
+	// Report is called once the payload has been fully sent (or failed), so
+	// transports that speak a request/response protocol can reflect that in
+	// their reply.
+	Report(err error)
+}
+
+// openCloneDestination opens the transport selected by -transport and returns the
+// stream the frame header, payload and digest will be written to.
+func openCloneDestination() (cloneDestination, error) {
+	switch *transportMode {
+	case transportHTTP:
+		return newHTTPCloneDestination()
+	case transportPipe:
+		return newPipeCloneDestination()
+	default:
+		return nil, fmt.Errorf("Unknown clone transport %q", *transportMode)
+	}
+}
+
+// pipeCloneDestination is the named-pipe transport: the source pod and target
+// share a pod/emptyDir and communicate over a FIFO, with a sibling control FIFO
+// carrying the resume point.
+type pipeCloneDestination struct {
+	io.WriteCloser
+}
+
+func newPipeCloneDestination() (cloneDestination, error) {
+	if *namedPipe == "nopipedir" {
+		return nil, fmt.Errorf("Missed named pipe flag")
+	}
+
+	f, err := os.OpenFile(*namedPipe, os.O_WRONLY, os.ModeNamedPipe)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeCloneDestination{WriteCloser: f}, nil
+}
+
+// ResumeFromChunk reads the single control message the target always writes
+// before opening the main pipe (see cmd/cdi-cloner's newPipeCloneSource).
+func (p *pipeCloneDestination) ResumeFromChunk() (uint64, error) {
+	ctrl, err := os.OpenFile(*namedPipe+controlPipeSuffix, os.O_RDONLY, os.ModeNamedPipe)
+	if err != nil {
+		return 0, err
+	}
+	defer ctrl.Close()
+
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(ctrl, b); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (p *pipeCloneDestination) Report(error) {}
+
+// httpCloneDestination streams the clone over a single mTLS-authenticated POST to
+// the target's /v1/clone, instead of requiring source and target to share a FIFO.
+type httpCloneDestination struct {
+	*io.PipeWriter
+	client *http.Client
+	respCh chan error
+}
+
+func newHTTPCloneDestination() (cloneDestination, error) {
+	cert, err := tls.LoadX509KeyPair(*clientCertFile, *clientKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := ioutil.ReadFile(*caCertFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("Unable to parse CA certificate for mTLS clone transport")
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				RootCAs:      pool,
+			},
+		},
+	}
+
+	return &httpCloneDestination{client: client, respCh: make(chan error, 1)}, nil
+}
+
+// ResumeFromChunk queries the target's resume-point endpoint before the clone
+// POST opens, so the payload can be staged starting at the right chunk.
+func (h *httpCloneDestination) ResumeFromChunk() (uint64, error) {
+	resp, err := h.client.Get(fmt.Sprintf("https://%s%s", *targetAddress, cloneResumePointPath))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var parsed resumePointResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return 0, err
+	}
+	return parsed.ResumeFromChunk, nil
+}
+
+// Write lazily starts the POST to cloneHTTPPath on the first write, streaming the
+// frame header, payload and digest through an io.Pipe as the request body instead
+// of buffering the whole clone in memory.
+func (h *httpCloneDestination) Write(p []byte) (int, error) {
+	if h.PipeWriter == nil {
+		pr, pw := io.Pipe()
+		h.PipeWriter = pw
+		go func() {
+			resp, err := h.client.Post(fmt.Sprintf("https://%s%s", *targetAddress, cloneHTTPPath), "application/octet-stream", pr)
+			if err == nil {
+				defer resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					err = fmt.Errorf("clone POST failed with status %s", resp.Status)
+				}
+			}
+			h.respCh <- err
+		}()
+	}
+	return h.PipeWriter.Write(p)
+}
+
+func (h *httpCloneDestination) Close() error {
+	if h.PipeWriter == nil {
+		return nil
+	}
+	return h.PipeWriter.Close()
+}
+
+// Report closes the request body, unblocking the POST goroutine, then waits for
+// the target's response so a failed clone is reflected as a non-2xx status
+// instead of this process exiting before the target has even replied.
+func (h *httpCloneDestination) Report(err error) {
+	if h.PipeWriter != nil {
+		h.PipeWriter.CloseWithError(err)
+		if respErr := <-h.respCh; respErr != nil && err == nil {
+			klog.Errorf("%+v", errors.Wrap(respErr, "target reported a failed clone"))
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/blake2b"
+	"k8s.io/klog"
+	"kubevirt.io/containerized-data-importer/pkg/common"
+	"kubevirt.io/containerized-data-importer/pkg/util/clone"
+)
+
+const (
+	cloneStateFileName = "clone-state.json"
+)
+
+var (
+	cloneResumedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "clone_resumed_total",
+		Help: "The total number of clones that resumed from a previously recorded partial transfer",
+	})
+	cloneChunksWritten = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "clone_chunks_written_total",
+		Help: "The total number of content-addressed chunks validated and written to the target",
+	})
+	cloneLastOffset = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "clone_last_offset_bytes",
+		Help: "The byte offset of the last contiguous chunk written to the target",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(cloneResumedTotal)
+	prometheus.MustRegister(cloneChunksWritten)
+	prometheus.MustRegister(cloneLastOffset)
+}
+
+// cloneState is persisted to cloneStateFileName, next to ImporterWriteBlockPath, so a
+// restarted target can tell the source which chunk to resume from instead of
+// re-streaming a multi-TB disk from the start.
+type cloneState struct {
+	LastContiguousChunk uint64 `json:"lastContiguousChunk"`
+	OffsetBytes         uint64 `json:"offsetBytes"`
+}
+
+func cloneStateFilePath() string {
+	return filepath.Join(filepath.Dir(common.ImporterWriteBlockPath), cloneStateFileName)
+}
+
+// loadCloneState reads the chunk/offset the previous attempt last durably recorded.
+// A missing state file just means this is the first attempt.
+func loadCloneState() (cloneState, error) {
+	b, err := ioutil.ReadFile(cloneStateFilePath())
+	if os.IsNotExist(err) {
+		return cloneState{}, nil
+	}
+	if err != nil {
+		return cloneState{}, err
+	}
+	var state cloneState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return cloneState{}, err
+	}
+	return state, nil
+}
+
+func saveCloneState(state cloneState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cloneStateFilePath(), b, 0644)
+}
+
+// streamBlockDataAtOffset resumes a block-device write by seeking to offset before
+// copying r, instead of the ordinary StreamDataToFile path which always writes from
+// the start of the device.
+func streamBlockDataAtOffset(r io.Reader, path string, offset int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// resumableChunkReader reads the chunked clone payload, validating each chunk's
+// sequence number and Blake2b-256 hash and persisting cloneState after every chunk so
+// a restarted target can resume past whatever the previous attempt already wrote.
+type resumableChunkReader struct {
+	io.Reader
+	state      cloneState
+	total      uint64
+	volumeMode string
+	nextChunk  uint64
+	chunk      []byte
+	chunkStart int
+}
+
+func newResumableChunkReader(r io.Reader, startState cloneState, total uint64, volumeMode string) *resumableChunkReader {
+	return &resumableChunkReader{Reader: r, state: startState, total: total, volumeMode: volumeMode, nextChunk: nextChunkToRequest(startState)}
+}
+
+// nextChunkToRequest is the chunk sequence number a resumed clone must ask the
+// source to start from. This is also what openCloneSource advertises as
+// resumeFromChunk, so a source that honors it sends exactly the chunk this reader
+// expects next instead of re-sending LastContiguousChunk, which the reader has
+// already written and would reject as out-of-sequence.
+func nextChunkToRequest(state cloneState) uint64 {
+	nextChunk := state.LastContiguousChunk
+	if state.OffsetBytes > 0 {
+		nextChunk++
+	}
+	return nextChunk
+}
+
+func (r *resumableChunkReader) Read(p []byte) (int, error) {
+	if r.chunkStart >= len(r.chunk) {
+		if r.state.OffsetBytes >= r.total {
+			return 0, io.EOF
+		}
+		if err := r.readNextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.chunk[r.chunkStart:])
+	r.chunkStart += n
+	return n, nil
+}
+
+func (r *resumableChunkReader) readNextChunk() error {
+	header, err := clone.ReadChunkHeader(r.Reader)
+	if err != nil {
+		return err
+	}
+
+	if header.Sequence != r.nextChunk {
+		cloneIntegrityFailures.WithLabelValues(ownerUID, r.volumeMode).Inc()
+		return errors.Errorf("expected clone chunk %d but received out-of-sequence chunk %d", r.nextChunk, header.Sequence)
+	}
+
+	buf := make([]byte, clone.ChunkSize)
+	n, err := io.ReadFull(r.Reader, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	buf = buf[:n]
+
+	sum := blake2b.Sum256(buf)
+	if sum != header.Hash {
+		cloneIntegrityFailures.WithLabelValues(ownerUID, r.volumeMode).Inc()
+		return errors.Errorf("clone chunk %d failed Blake2b integrity check", header.Sequence)
+	}
+
+	r.state.LastContiguousChunk = header.Sequence
+	r.state.OffsetBytes += uint64(n)
+	r.nextChunk++
+	if err := saveCloneState(r.state); err != nil {
+		klog.Errorf("%+v", errors.Wrap(err, "failed to persist clone-state.json"))
+	}
+
+	cloneChunksWritten.Inc()
+	cloneLastOffset.Set(float64(r.state.OffsetBytes))
+
+	r.chunk = buf
+	r.chunkStart = 0
+	return nil
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"os"
-	"strconv"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
@@ -16,16 +20,24 @@ import (
 	"k8s.io/klog"
 	"kubevirt.io/containerized-data-importer/pkg/common"
 	"kubevirt.io/containerized-data-importer/pkg/util"
+	"kubevirt.io/containerized-data-importer/pkg/util/clone"
 	prometheusutil "kubevirt.io/containerized-data-importer/pkg/util/prometheus"
 )
 
 type prometheusProgressReader struct {
 	util.CountingReader
-	total uint64
+	total      uint64
+	volumeMode string
 }
 
 const (
 	maxSizeLength = 20
+
+	phaseSizeHeaderRead = "size_header_read"
+	phasePipeOpen       = "pipe_open"
+	phaseStream         = "stream"
+	phaseUntar          = "untar"
+	phaseBlockWrite     = "block_write"
 )
 
 var (
@@ -36,8 +48,15 @@ var (
 		},
 		[]string{"ownerUID"},
 	)
-	ownerUID  string
-	namedPipe *string
+	cloneOps               = prometheusutil.CloneOps
+	cloneErrors            = prometheusutil.CloneErrors
+	cloneBytesIn           = prometheusutil.CloneBytesIn
+	cloneBytesOut          = prometheusutil.CloneBytesOut
+	cloneIntegrityFailures = prometheusutil.CloneIntegrityFailures
+	cloneChunkLatency      = prometheusutil.CloneChunkLatency
+	clonePhaseDuration     = prometheusutil.ClonePhaseDuration
+	ownerUID               string
+	namedPipe              *string
 )
 
 func init() {
@@ -46,9 +65,17 @@ func init() {
 	flag.Parse()
 
 	prometheus.MustRegister(progress)
+	prometheusutil.RegisterCloneMetrics()
 	ownerUID, _ = util.ParseEnvVar(common.OwnerUID, false)
 }
 
+// observePhase records the wall time a clone phase took against the shared
+// prometheusutil registry so the importer and upload-server can adopt the
+// same metric schema.
+func observePhase(volumeMode, phase string, start time.Time) {
+	clonePhaseDuration.WithLabelValues(ownerUID, volumeMode, phase).Observe(time.Since(start).Seconds())
+}
+
 func main() {
 	defer klog.Flush()
 	klog.V(1).Infoln("Starting cloner target")
@@ -59,64 +86,198 @@ func main() {
 	defer os.RemoveAll(certsDirectory)
 	prometheusutil.StartPrometheusEndpoint(certsDirectory)
 
-	if *namedPipe == "nopipedir" {
-		klog.Errorf("%+v", fmt.Errorf("Missed named pipe flag"))
+	volumeMode := string(v1.PersistentVolumeBlock)
+	if _, err := os.Stat(common.ImporterWriteBlockPath); os.IsNotExist(err) {
+		volumeMode = string(v1.PersistentVolumeFilesystem)
+	}
+
+	state, err := loadCloneState()
+	if err != nil {
+		klog.Errorf("%+v", err)
 		os.Exit(1)
 	}
+	if volumeMode == string(v1.PersistentVolumeFilesystem) && (state.LastContiguousChunk > 0 || state.OffsetBytes > 0) {
+		// A tar stream can only be extracted from the start: there's no way to seek
+		// the untar destination to "the byte after the last chunk we wrote", so
+		// resume only applies to raw block writes. Discard the stale state rather
+		// than asking the source to skip chunks we'd then fail to place correctly.
+		klog.Warningf("Discarding clone-state.json: filesystem clones cannot resume from an arbitrary byte offset, restarting from scratch")
+		state = cloneState{}
+	}
+	if state.OffsetBytes > 0 {
+		cloneResumedTotal.Inc()
+		klog.V(1).Infof("Resuming clone from chunk %d (%d bytes already written)", nextChunkToRequest(state), state.OffsetBytes)
+	}
 
-	total, err := collectTotalSize()
+	pipeOpenStart := time.Now()
+	src, err := openCloneSource(certsDirectory, nextChunkToRequest(state))
+	observePhase(volumeMode, phasePipeOpen, pipeOpenStart)
 	if err != nil {
+		cloneErrors.WithLabelValues(ownerUID, volumeMode, phasePipeOpen).Inc()
 		klog.Errorf("%+v", err)
 		os.Exit(1)
 	}
-	klog.V(3).Infof("Size read: %d\n", total)
+	defer src.Close()
 
-	//re-open pipe with fresh start.
-	out, err := os.OpenFile(*namedPipe, os.O_RDONLY, os.ModeNamedPipe)
+	sizeHeaderStart := time.Now()
+	header, err := clone.ReadFrameHeader(src)
+	observePhase(volumeMode, phaseSizeHeaderRead, sizeHeaderStart)
 	if err != nil {
+		cloneErrors.WithLabelValues(ownerUID, volumeMode, phaseSizeHeaderRead).Inc()
+		src.Report(err)
 		klog.Errorf("%+v", err)
 		os.Exit(1)
 	}
-	defer out.Close()
+	klog.V(3).Infof("Size read: %d, compression: %s\n", header.Total, header.Compression)
+
+	bytesPerSec, burst := resolveThrottleSettings()
+	limitedSrc := util.NewRateLimitedReader(src, bytesPerSec, burst)
+	limitedSrc.OnSleep = func(d time.Duration) {
+		cloneThrottleSleepSeconds.Add(d.Seconds())
+	}
 
 	promReader := &prometheusProgressReader{
 		CountingReader: util.CountingReader{
-			Reader:  out,
+			Reader:  limitedSrc,
 			Current: 0,
 		},
-		total: total,
+		// promReader sits below the decompressor and counts wire bytes read off
+		// limitedSrc, so progress has to be measured against compressedLen (the
+		// on-wire size) rather than header.total (the uncompressed size), or the
+		// gauge would never reach 100% for gzip/zstd and overshoot slightly for
+		// uncompressed payloads, whose wire size also includes the per-chunk
+		// headers that header.total excludes.
+		total:      header.CompressedLen,
+		volumeMode: volumeMode,
 	}
 
 	// Start the progress update thread.
 	go promReader.timedUpdateProgress()
 
-	volumeMode := v1.PersistentVolumeBlock
-	if _, err := os.Stat(common.ImporterWriteBlockPath); os.IsNotExist(err) {
-		volumeMode = v1.PersistentVolumeFilesystem
+	// Bound the (possibly compressed) segment to exactly header.CompressedLen bytes
+	// so a decompressor's internal buffering (e.g. compress/flate wraps its input in
+	// a bufio.Reader) can never pull ahead into the trailing digest that follows it
+	// on the wire.
+	compressedReader := io.LimitReader(promReader, int64(header.CompressedLen))
+
+	var payloadReader io.Reader
+	switch header.Compression {
+	case clone.CompressionGzip:
+		gzReader, err := gzip.NewReader(compressedReader)
+		if err != nil {
+			cloneErrors.WithLabelValues(ownerUID, volumeMode, phaseStream).Inc()
+			klog.Errorf("%+v", err)
+			os.Exit(1)
+		}
+		defer gzReader.Close()
+		payloadReader = gzReader
+	case clone.CompressionZstd:
+		zstdReader, err := zstd.NewReader(compressedReader)
+		if err != nil {
+			cloneErrors.WithLabelValues(ownerUID, volumeMode, phaseStream).Inc()
+			klog.Errorf("%+v", err)
+			os.Exit(1)
+		}
+		defer zstdReader.Close()
+		payloadReader = zstdReader
+	default:
+		payloadReader = compressedReader
 	}
-	if volumeMode == v1.PersistentVolumeBlock {
-		klog.V(3).Infoln("Writing data to block device")
-		err = util.StreamDataToFile(promReader, common.ImporterWriteBlockPath)
+
+	chunkReader := newResumableChunkReader(payloadReader, state, header.Total, volumeMode)
+
+	digest := sha256.New()
+	writerReader := &countingHashReader{
+		Reader:     io.TeeReader(chunkReader, digest),
+		volumeMode: volumeMode,
+	}
+
+	streamStart := time.Now()
+	if volumeMode == string(v1.PersistentVolumeBlock) {
+		if state.OffsetBytes > 0 {
+			klog.V(3).Infof("Writing data to block device at resumed offset %d", state.OffsetBytes)
+			err = streamBlockDataAtOffset(writerReader, common.ImporterWriteBlockPath, int64(state.OffsetBytes))
+		} else {
+			klog.V(3).Infoln("Writing data to block device")
+			err = util.StreamDataToFile(writerReader, common.ImporterWriteBlockPath)
+		}
+		observePhase(volumeMode, phaseBlockWrite, streamStart)
 	} else {
 		klog.V(3).Infoln("Writing data to file system")
-		err = util.UnArchiveTar(promReader, ".")
+		err = util.UnArchiveTar(writerReader, ".")
+		observePhase(volumeMode, phaseUntar, streamStart)
 	}
 
 	if err != nil {
+		cloneErrors.WithLabelValues(ownerUID, volumeMode, phaseStream).Inc()
+		src.Report(err)
+		klog.Errorf("%+v", err)
+		os.Exit(1)
+	}
+
+	if err := verifyCloneIntegrity(promReader, compressedReader, digest, state.OffsetBytes > 0); err != nil {
+		cloneIntegrityFailures.WithLabelValues(ownerUID, volumeMode).Inc()
+		src.Report(err)
 		klog.Errorf("%+v", err)
 		os.Exit(1)
 	}
+
+	src.Report(nil)
 	klog.V(1).Infoln("clone complete")
 }
 
-func collectTotalSize() (uint64, error) {
-	klog.V(3).Infoln("Reading total size")
-	out, err := os.OpenFile(*namedPipe, os.O_RDONLY, os.ModeNamedPipe)
-	if err != nil {
-		return uint64(0), err
+// verifyCloneIntegrity drains whatever compressedReader's decompressor left
+// unconsumed — for gzip/zstd the decompressor stops once it has produced
+// header.Total bytes of plaintext and never reads its own footer, so without
+// draining, the "trailer" read below would actually be leftover compressed bytes —
+// then reads and checks the trailing SHA-256 digest that follows the payload on the
+// wire. On a resumed clone that digest covers the whole payload, not just the bytes
+// streamed this attempt, so it can never match; the comparison is skipped in that
+// case because every chunk written this attempt already passed its own Blake2b
+// check in resumableChunkReader.
+func verifyCloneIntegrity(trailerSrc io.Reader, compressedReader io.Reader, digest hash.Hash, resumed bool) error {
+	if _, err := io.Copy(ioutil.Discard, compressedReader); err != nil {
+		return errors.Wrap(err, "failed to drain trailing compressed bytes")
+	}
+
+	trailer := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(trailerSrc, trailer); err != nil {
+		return errors.Wrap(err, "failed to read clone integrity digest")
 	}
-	defer out.Close()
-	return readTotal(out)
+
+	if !resumed && !bytes.Equal(trailer, digest.Sum(nil)) {
+		return fmt.Errorf("clone integrity check failed: digest mismatch")
+	}
+	return nil
+}
+
+// Read observes per-chunk latency and byte throughput against the clone_* family
+// so operators can build instantaneous MB/s and tail latency dashboards, not just
+// a single completion percentage.
+func (r *prometheusProgressReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.CountingReader.Read(p)
+	cloneChunkLatency.WithLabelValues(ownerUID, r.volumeMode).Observe(time.Since(start).Seconds())
+	if n > 0 {
+		cloneOps.WithLabelValues(ownerUID, r.volumeMode).Inc()
+		cloneBytesIn.WithLabelValues(ownerUID, r.volumeMode).Add(float64(n))
+	}
+	return n, err
+}
+
+// countingHashReader tracks the number of uncompressed bytes handed to the writer
+// after the decompression and digest layers, feeding clone_bytes_out.
+type countingHashReader struct {
+	io.Reader
+	volumeMode string
+}
+
+func (r *countingHashReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		cloneBytesOut.WithLabelValues(ownerUID, r.volumeMode).Add(float64(n))
+	}
+	return n, err
 }
 
 func (r *prometheusProgressReader) timedUpdateProgress() {
@@ -138,19 +299,3 @@ func (r *prometheusProgressReader) updateProgress() {
 		klog.V(1).Infoln(fmt.Sprintf("%.2f", currentProgress))
 	}
 }
-
-// read total file size from reader, and return the value as an int64
-func readTotal(r io.Reader) (uint64, error) {
-	b := make([]byte, 16)
-
-	n, err := r.Read(b)
-	if err != nil {
-		klog.Errorf("%+v", err)
-		return uint64(0), err
-	}
-	if n != len(b) {
-		// Didn't read all 16 bytes..
-		return uint64(0), errors.New("Didn't read all bytes for size header")
-	}
-	return strconv.ParseUint(string(b), 16, 64)
-}
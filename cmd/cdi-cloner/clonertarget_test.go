@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"testing"
+)
+
+// Frame header parsing itself is covered by pkg/util/clone, which owns the wire
+// format; the tests below only cover this package's own verifyCloneIntegrity logic.
+
+func TestVerifyCloneIntegrityDrainsLeftoverCompressedBytes(t *testing.T) {
+	payload := []byte("the quick brown fox jumps over the lazy dog")
+	sum := sha256.Sum256(payload)
+
+	// Simulate a decompressor that stopped short of compressedReader's end,
+	// leaving footer bytes in front of the trailer on the wire.
+	leftoverFooter := []byte{0xde, 0xad, 0xbe, 0xef}
+	wire := append(append([]byte{}, leftoverFooter...), sum[:]...)
+
+	digest := sha256.New()
+	digest.Write(payload)
+
+	// As in main, compressedReader is a bounded view of the same stream the trailer
+	// is read from, so draining it advances that stream past the leftover bytes.
+	src := bytes.NewReader(wire)
+	compressedReader := io.LimitReader(src, int64(len(leftoverFooter)))
+
+	if err := verifyCloneIntegrity(src, compressedReader, digest, false); err != nil {
+		t.Fatalf("verifyCloneIntegrity returned error: %v", err)
+	}
+}
+
+func TestVerifyCloneIntegrityRejectsMismatchedDigest(t *testing.T) {
+	digest := sha256.New()
+	digest.Write([]byte("actual payload"))
+
+	wrongTrailer := sha256.Sum256([]byte("some other payload"))
+
+	err := verifyCloneIntegrity(bytes.NewReader(wrongTrailer[:]), bytes.NewReader(nil), digest, false)
+	if err == nil {
+		t.Fatal("expected an error for a mismatched digest, got nil")
+	}
+}
+
+func TestVerifyCloneIntegritySkipsDigestCheckWhenResumed(t *testing.T) {
+	digest := sha256.New()
+	digest.Write([]byte("only the resumed tail"))
+
+	wholePayloadTrailer := sha256.Sum256([]byte("the full original payload"))
+
+	if err := verifyCloneIntegrity(bytes.NewReader(wholePayloadTrailer[:]), bytes.NewReader(nil), digest, true); err != nil {
+		t.Fatalf("verifyCloneIntegrity returned error for a resumed clone: %v", err)
+	}
+}
+
+func TestVerifyCloneIntegrityRejectsShortTrailer(t *testing.T) {
+	digest := sha256.New()
+
+	err := verifyCloneIntegrity(bytes.NewReader(nil), bytes.NewReader(nil), digest, false)
+	if err == nil {
+		t.Fatal("expected an error when the trailer can't be fully read, got nil")
+	}
+	if err == io.EOF {
+		t.Fatalf("expected a wrapped error, got bare io.EOF")
+	}
+}
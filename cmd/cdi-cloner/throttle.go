@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"kubevirt.io/containerized-data-importer/pkg/util"
+)
+
+const (
+	maxBytesPerSecEnvVar = "CLONE_MAX_BYTES_PER_SEC"
+	burstBytesEnvVar     = "CLONE_BURST_BYTES"
+)
+
+var (
+	maxBytesPerSec = flag.Int64("max-bytes-per-sec", 0, "The maximum sustained read rate, in bytes/sec, the cloner target will accept (0 disables throttling)")
+	burstBytes     = flag.Int64("burst-bytes", 0, "The burst size, in bytes, allowed above -max-bytes-per-sec (defaults to -max-bytes-per-sec)")
+
+	cloneThrottleSleepSeconds = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "clone_throttle_sleep_seconds_total",
+		Help: "The total time spent sleeping to honor the configured clone rate limit",
+	})
+	cloneRateLimitBytesPerSecond = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "clone_rate_limit_bytes_per_second",
+		Help: "The currently configured clone rate limit in bytes/sec (0 means unthrottled)",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(cloneThrottleSleepSeconds)
+	prometheus.MustRegister(cloneRateLimitBytesPerSecond)
+}
+
+// resolveThrottleSettings lets the CDI controller cap a clone's rate per-DataVolume
+// via environment variables, falling back to the -max-bytes-per-sec/-burst-bytes
+// flag defaults when unset.
+func resolveThrottleSettings() (int, int) {
+	bytesPerSec := int(*maxBytesPerSec)
+	if v, err := util.ParseEnvVar(maxBytesPerSecEnvVar, false); err == nil && v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
+			bytesPerSec = int(parsed)
+		}
+	}
+
+	burst := int(*burstBytes)
+	if v, err := util.ParseEnvVar(burstBytesEnvVar, false); err == nil && v != "" {
+		if parsed, err := strconv.ParseInt(v, 10, 64); err == nil {
+			burst = int(parsed)
+		}
+	}
+	if burst <= 0 {
+		burst = bytesPerSec
+	}
+
+	cloneRateLimitBytesPerSecond.Set(float64(bytesPerSec))
+	return bytesPerSec, burst
+}
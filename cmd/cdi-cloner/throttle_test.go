@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestResolveThrottleSettingsDefaultsBurstToBytesPerSec(t *testing.T) {
+	originalRate, originalBurst := *maxBytesPerSec, *burstBytes
+	defer func() { *maxBytesPerSec, *burstBytes = originalRate, originalBurst }()
+
+	*maxBytesPerSec = 1000
+	*burstBytes = 0
+
+	bytesPerSec, burst := resolveThrottleSettings()
+	if bytesPerSec != 1000 {
+		t.Errorf("bytesPerSec = %d, want 1000", bytesPerSec)
+	}
+	if burst != 1000 {
+		t.Errorf("burst = %d, want 1000 (falls back to bytesPerSec)", burst)
+	}
+}
+
+func TestResolveThrottleSettingsKeepsExplicitBurst(t *testing.T) {
+	originalRate, originalBurst := *maxBytesPerSec, *burstBytes
+	defer func() { *maxBytesPerSec, *burstBytes = originalRate, originalBurst }()
+
+	*maxBytesPerSec = 1000
+	*burstBytes = 64
+
+	_, burst := resolveThrottleSettings()
+	if burst != 64 {
+		t.Errorf("burst = %d, want 64", burst)
+	}
+}
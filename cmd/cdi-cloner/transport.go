@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog"
+)
+
+const (
+	transportPipe = "pipe"
+	transportHTTP = "http"
+
+	// cloneHTTPPort is the port the target listens on in http transport mode. It is
+	// separate from the Prometheus endpoint but reuses the same cert material.
+	cloneHTTPPort        = 8443
+	cloneHTTPPath        = "/v1/clone"
+	cloneResumePointPath = "/v1/resume-point"
+)
+
+// resumePointResponse is served from cloneResumePointPath so a source pod can query
+// where to resume before POSTing the clone stream.
+type resumePointResponse struct {
+	ResumeFromChunk uint64 `json:"resumeFromChunk"`
+}
+
+var (
+	transportMode = flag.String("transport", transportPipe, "The clone transport to use: pipe (shared FIFO) or http (mTLS streaming)")
+	caCertFile    = flag.String("ca-cert-file", "", "Path to the CA certificate, mounted from a Secret, used to authenticate the source pod over mTLS (http transport only)")
+
+	httpCloneRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "clone_http_requests_total",
+			Help: "The total number of HTTP clone requests received, by remote peer and status",
+		},
+		[]string{"remote_peer", "status"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(httpCloneRequests)
+}
+
+// cloneSource is a transport-agnostic view of the incoming clone stream: the frame
+// header, payload and trailing digest are all read off it in order, the same way
+// regardless of whether they arrived over a named pipe or an HTTP request body.
+type cloneSource interface {
+	io.ReadCloser
+	// Report is called once the payload has been fully consumed (or failed), so
+	// transports that speak a request/response protocol can reply accordingly.
+	Report(err error)
+}
+
+// openCloneSource opens the transport selected by -transport and returns the stream
+// the frame header, payload and digest will be read from. resumeFromChunk is the
+// chunk sequence number the target expects next (0 if this is the first attempt,
+// see nextChunkToRequest); it is relayed to the source so it sends that chunk first
+// instead of re-streaming chunks the target already has.
+func openCloneSource(certsDirectory string, resumeFromChunk uint64) (cloneSource, error) {
+	switch *transportMode {
+	case transportHTTP:
+		return newHTTPCloneSource(certsDirectory, resumeFromChunk)
+	case transportPipe:
+		return newPipeCloneSource(resumeFromChunk)
+	default:
+		return nil, fmt.Errorf("Unknown clone transport %q", *transportMode)
+	}
+}
+
+// pipeCloneSource is the original named-pipe transport: the source pod and target
+// share a pod/emptyDir and communicate over a FIFO.
+type pipeCloneSource struct {
+	io.ReadCloser
+}
+
+// controlPipeSuffix names the sibling FIFO the target uses to tell the source which
+// chunk to resume from, alongside the main data pipe.
+const controlPipeSuffix = ".ctrl"
+
+func newPipeCloneSource(resumeFromChunk uint64) (cloneSource, error) {
+	if *namedPipe == "nopipedir" {
+		return nil, fmt.Errorf("Missed named pipe flag")
+	}
+
+	// Always write the control message, even when resumeFromChunk is 0, so the
+	// source always has exactly one control message to read instead of having to
+	// guess whether the target is going to send one before opening the main pipe.
+	if err := writeResumeControlMessage(*namedPipe+controlPipeSuffix, resumeFromChunk); err != nil {
+		klog.Errorf("%+v", errors.Wrap(err, "failed to request resume over the control pipe, restarting from scratch"))
+	}
+
+	f, err := os.OpenFile(*namedPipe, os.O_RDONLY, os.ModeNamedPipe)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeCloneSource{ReadCloser: f}, nil
+}
+
+// writeResumeControlMessage tells the source, over the sibling control FIFO, to
+// skip ahead to resumeFromChunk instead of re-streaming the whole disk.
+func writeResumeControlMessage(controlPipe string, resumeFromChunk uint64) error {
+	ctrl, err := os.OpenFile(controlPipe, os.O_WRONLY, os.ModeNamedPipe)
+	if err != nil {
+		return err
+	}
+	defer ctrl.Close()
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, resumeFromChunk)
+	_, err = ctrl.Write(b)
+	return err
+}
+
+func (p *pipeCloneSource) Report(error) {}
+
+// httpCloneSource accepts a single mTLS-authenticated POST to /v1/clone instead of
+// requiring source and target to share a FIFO, so a clone can cross nodes without a
+// shared volume.
+type httpCloneSource struct {
+	io.ReadCloser
+	server   *http.Server
+	remote   string
+	resultCh chan error
+}
+
+func newHTTPCloneSource(certsDirectory string, resumeFromChunk uint64) (cloneSource, error) {
+	caCert, err := ioutil.ReadFile(*caCertFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("Unable to parse CA certificate for mTLS clone transport")
+	}
+
+	srcCh := make(chan *httpCloneSource, 1)
+	errCh := make(chan error, 1)
+
+	mux := http.NewServeMux()
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cloneHTTPPort),
+		Handler: mux,
+		TLSConfig: &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  pool,
+		},
+	}
+
+	mux.HandleFunc(cloneResumePointPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resumePointResponse{ResumeFromChunk: resumeFromChunk}); err != nil {
+			klog.Errorf("%+v", err)
+		}
+	})
+
+	mux.HandleFunc(cloneHTTPPath, func(w http.ResponseWriter, r *http.Request) {
+		remote := r.RemoteAddr
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			remote = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		src := &httpCloneSource{
+			ReadCloser: r.Body,
+			server:     server,
+			remote:     remote,
+			resultCh:   make(chan error, 1),
+		}
+		srcCh <- src
+
+		status := "ok"
+		if err := <-src.resultCh; err != nil {
+			status = "error"
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		httpCloneRequests.WithLabelValues(remote, status).Inc()
+	})
+
+	go func() {
+		certFile := filepath.Join(certsDirectory, "tls.crt")
+		keyFile := filepath.Join(certsDirectory, "tls.key")
+		if err := server.ListenAndServeTLS(certFile, keyFile); err != nil && err != http.ErrServerClosed {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case src := <-srcCh:
+		return src, nil
+	case err := <-errCh:
+		return nil, err
+	}
+}
+
+func (h *httpCloneSource) Report(err error) {
+	h.resultCh <- err
+	// Shutdown waits for the handler goroutine to finish writing its response
+	// before the listener goes away, unlike Close, which would tear down the
+	// connection out from under it and could surface a successful clone to the
+	// source as a connection reset.
+	if closeErr := h.server.Shutdown(context.Background()); closeErr != nil {
+		klog.Errorf("%+v", closeErr)
+	}
+}
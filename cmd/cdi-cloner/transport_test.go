@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestOpenCloneSourceRejectsUnknownTransport(t *testing.T) {
+	original := *transportMode
+	defer func() { *transportMode = original }()
+
+	*transportMode = "bogus"
+	if _, err := openCloneSource(t.TempDir(), 0); err == nil {
+		t.Fatal("expected an error for an unknown -transport value, got nil")
+	}
+}
+
+func TestOpenCloneSourcePipeMissingFlag(t *testing.T) {
+	originalTransport := *transportMode
+	originalPipe := *namedPipe
+	defer func() {
+		*transportMode = originalTransport
+		*namedPipe = originalPipe
+	}()
+
+	*transportMode = transportPipe
+	*namedPipe = "nopipedir"
+	if _, err := openCloneSource(t.TempDir(), 0); err == nil {
+		t.Fatal("expected an error when -pipedir was never set, got nil")
+	}
+}
@@ -0,0 +1,48 @@
+package clone
+
+import (
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	// ChunkSize is the fixed size of each content-addressed chunk in the
+	// resumable clone protocol.
+	ChunkSize = 4 * 1024 * 1024
+
+	// ChunkHeaderLen is a sequence number (8 bytes) plus a Blake2b-256 hash (32
+	// bytes) prefixing every chunk on the wire.
+	ChunkHeaderLen = 8 + blake2b.Size256
+)
+
+// ChunkHeader is the per-chunk framing the source writes ahead of every
+// ChunkSize block of payload: a monotonically increasing sequence number and a
+// Blake2b-256 hash of the chunk's plaintext, letting the target detect
+// corruption and resume from the last chunk it durably recorded.
+type ChunkHeader struct {
+	Sequence uint64
+	Hash     [blake2b.Size256]byte
+}
+
+// ReadChunkHeader parses a ChunkHeader off r.
+func ReadChunkHeader(r io.Reader) (ChunkHeader, error) {
+	b := make([]byte, ChunkHeaderLen)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return ChunkHeader{}, err
+	}
+	var header ChunkHeader
+	header.Sequence = binary.BigEndian.Uint64(b[:8])
+	copy(header.Hash[:], b[8:])
+	return header, nil
+}
+
+// WriteChunkHeader serializes header and writes it to w.
+func WriteChunkHeader(w io.Writer, header ChunkHeader) error {
+	b := make([]byte, ChunkHeaderLen)
+	binary.BigEndian.PutUint64(b[:8], header.Sequence)
+	copy(b[8:], header.Hash[:])
+	_, err := w.Write(b)
+	return err
+}
@@ -0,0 +1,32 @@
+package clone
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestChunkHeaderRoundTrips(t *testing.T) {
+	want := ChunkHeader{Sequence: 7}
+	want.Hash = blake2b.Sum256([]byte("chunk payload"))
+
+	var buf bytes.Buffer
+	if err := WriteChunkHeader(&buf, want); err != nil {
+		t.Fatalf("WriteChunkHeader returned error: %v", err)
+	}
+
+	got, err := ReadChunkHeader(&buf)
+	if err != nil {
+		t.Fatalf("ReadChunkHeader returned error: %v", err)
+	}
+	if got != want {
+		t.Errorf("ReadChunkHeader = %+v, want %+v", got, want)
+	}
+}
+
+func TestReadChunkHeaderRejectsShortInput(t *testing.T) {
+	if _, err := ReadChunkHeader(bytes.NewReader(make([]byte, ChunkHeaderLen-1))); err == nil {
+		t.Fatal("expected an error for a truncated chunk header, got nil")
+	}
+}
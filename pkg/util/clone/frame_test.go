@@ -0,0 +1,58 @@
+package clone
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFrameHeaderRoundTrips(t *testing.T) {
+	want := FrameHeader{Version: FrameVersion1, Compression: CompressionZstd, Total: 1024, CompressedLen: 512}
+
+	var buf bytes.Buffer
+	if err := WriteFrameHeader(&buf, want); err != nil {
+		t.Fatalf("WriteFrameHeader returned error: %v", err)
+	}
+
+	got, err := ReadFrameHeader(&buf)
+	if err != nil {
+		t.Fatalf("ReadFrameHeader returned error: %v", err)
+	}
+	if got != want {
+		t.Errorf("ReadFrameHeader = %+v, want %+v", got, want)
+	}
+}
+
+func TestReadFrameHeaderRejectsUnknownMagic(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrameHeader(&buf, FrameHeader{Version: FrameVersion1}); err != nil {
+		t.Fatalf("WriteFrameHeader returned error: %v", err)
+	}
+	b := buf.Bytes()
+	b[0] = 'X'
+
+	if _, err := ReadFrameHeader(bytes.NewReader(b)); err == nil {
+		t.Fatal("expected an error for an unrecognized frame magic, got nil")
+	}
+}
+
+func TestReadFrameHeaderRejectsUnknownVersion(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrameHeader(&buf, FrameHeader{Version: FrameVersion1 + 1}); err != nil {
+		t.Fatalf("WriteFrameHeader returned error: %v", err)
+	}
+
+	if _, err := ReadFrameHeader(&buf); err == nil {
+		t.Fatal("expected an error for an unsupported frame version, got nil")
+	}
+}
+
+func TestReadFrameHeaderRejectsShortInput(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrameHeader(&buf, FrameHeader{Version: FrameVersion1}); err != nil {
+		t.Fatalf("WriteFrameHeader returned error: %v", err)
+	}
+
+	if _, err := ReadFrameHeader(bytes.NewReader(buf.Bytes()[:FrameHeaderLen-1])); err == nil {
+		t.Fatal("expected an error for a truncated frame header, got nil")
+	}
+}
@@ -0,0 +1,75 @@
+package prometheus
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// The Clone* metrics below are owned here, rather than as unexported vars in
+// cmd/cdi-cloner, so that the importer and upload-server can eventually record
+// against the same names instead of each growing their own ad hoc clone schema.
+var (
+	CloneOps = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "clone_ops_total",
+			Help: "The total number of clone read operations performed",
+		},
+		[]string{"ownerUID", "volumeMode"},
+	)
+	CloneErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "clone_errors_total",
+			Help: "The total number of errors encountered while cloning",
+		},
+		[]string{"ownerUID", "volumeMode", "phase"},
+	)
+	CloneBytesIn = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "clone_bytes_in",
+			Help: "The total number of (possibly compressed) bytes read off the clone pipe",
+		},
+		[]string{"ownerUID", "volumeMode"},
+	)
+	CloneBytesOut = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "clone_bytes_out",
+			Help: "The total number of uncompressed bytes written to the target after decompression",
+		},
+		[]string{"ownerUID", "volumeMode"},
+	)
+	CloneIntegrityFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "clone_integrity_failures_total",
+			Help: "The total number of clone integrity failures: out-of-sequence or Blake2b-mismatched chunks, and missing or mismatched trailing SHA-256 digests",
+		},
+		[]string{"ownerUID", "volumeMode"},
+	)
+	CloneChunkLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "clone_chunk_read_latency_seconds",
+			Help:    "The latency of each read from the clone pipe",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"ownerUID", "volumeMode"},
+	)
+	ClonePhaseDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "clone_phase_duration_seconds",
+			Help:    "The wall time spent in each phase of the clone",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"ownerUID", "volumeMode", "phase"},
+	)
+)
+
+// RegisterCloneMetrics registers the shared clone metrics against the default
+// Prometheus registry. Callers (cmd/cdi-cloner today) should invoke this once
+// from init.
+func RegisterCloneMetrics() {
+	prometheus.MustRegister(
+		CloneOps,
+		CloneErrors,
+		CloneBytesIn,
+		CloneBytesOut,
+		CloneIntegrityFailures,
+		CloneChunkLatency,
+		ClonePhaseDuration,
+	)
+}
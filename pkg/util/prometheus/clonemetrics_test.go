@@ -0,0 +1,30 @@
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRegisterCloneMetricsRegistersOnce(t *testing.T) {
+	// RegisterCloneMetrics is expected to be called exactly once per process (from
+	// cmd/cdi-cloner's init); a second call should panic via MustRegister on the
+	// duplicate registration rather than silently double-registering.
+	RegisterCloneMetrics()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a second RegisterCloneMetrics call to panic on duplicate registration")
+		}
+	}()
+	RegisterCloneMetrics()
+}
+
+func TestCloneOpsLabelsAreOwnerUIDAndVolumeMode(t *testing.T) {
+	CloneOps.Reset()
+	CloneOps.WithLabelValues("owner-1", "Block").Inc()
+
+	if got := testutil.ToFloat64(CloneOps.WithLabelValues("owner-1", "Block")); got != 1 {
+		t.Fatalf("CloneOps = %v, want 1", got)
+	}
+}
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedReaderDisabledWhenBytesPerSecIsZero(t *testing.T) {
+	r := NewRateLimitedReader(bytes.NewReader([]byte("hello")), 0, 0)
+	if r.limiter != nil {
+		t.Fatal("expected no limiter when bytesPerSec <= 0")
+	}
+}
+
+func TestRateLimitedReaderHandlesReadsLargerThanBurst(t *testing.T) {
+	// A read bigger than the configured burst must not make WaitN fail outright; it
+	// should be split into burst-sized waits instead.
+	payload := bytes.Repeat([]byte{0x01}, 256)
+	r := NewRateLimitedReader(bytes.NewReader(payload), 1<<20, 16)
+
+	done := make(chan struct{})
+	var got []byte
+	var err error
+	go func() {
+		got, err = ioutil.ReadAll(r)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadAll did not return, WaitN likely errored or blocked on an oversized request")
+	}
+
+	if err != nil {
+		t.Fatalf("ReadAll returned error: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("read %d bytes, want %d", len(got), len(payload))
+	}
+}